@@ -1,26 +1,29 @@
 package main
 
 import (
-	"bytes"
-	"compress/gzip"
-	"encoding/json"
+	"context"
 	"fmt"
-	"io"
 	"log"
 	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
 	"time"
 
 	"github.com/buildkite/go-buildkite/buildkite"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/singleflight"
 )
 
 type Build struct {
 	ID          string
 	Pipeline    Pipeline
 	Branch      string
-	ScheduledAt time.Time
-	FinishedAt  time.Time
-	StartedAt   time.Time
-	CreatedAt   time.Time
+	State       string
+	ScheduledAt *time.Time
+	FinishedAt  *time.Time
+	StartedAt   *time.Time
+	CreatedAt   *time.Time
 }
 
 type Pipeline struct {
@@ -28,6 +31,10 @@ type Pipeline struct {
 }
 
 // Mapping to an internal struct will use a lot less memory.
+//
+// Unlike passed builds, builds in other states (e.g. blocked, scheduled)
+// may not have every timestamp set yet, so each one is nil-checked rather
+// than unconditionally dereferenced.
 func newBuildFromBuildkite(b buildkite.Build) Build {
 	res := Build{
 		ID: *b.ID,
@@ -35,15 +42,28 @@ func newBuildFromBuildkite(b buildkite.Build) Build {
 			Name: *b.Pipeline.Name,
 		},
 		Branch: *b.Branch,
+	}
 
-		// We can safely assumed that all timestamps are set in the input, as
-		// we have a requirement that all builds should be finished when
-		// querying from Buildkite.
-		CreatedAt:   b.CreatedAt.Time,
-		StartedAt:   b.StartedAt.Time,
-		ScheduledAt: b.ScheduledAt.Time,
-		FinishedAt:  b.FinishedAt.Time,
+	if b.State != nil {
+		res.State = *b.State
+	}
+	if b.CreatedAt != nil {
+		t := b.CreatedAt.Time
+		res.CreatedAt = &t
+	}
+	if b.StartedAt != nil {
+		t := b.StartedAt.Time
+		res.StartedAt = &t
+	}
+	if b.ScheduledAt != nil {
+		t := b.ScheduledAt.Time
+		res.ScheduledAt = &t
+	}
+	if b.FinishedAt != nil {
+		t := b.FinishedAt.Time
+		res.FinishedAt = &t
 	}
+
 	return res
 }
 
@@ -59,6 +79,112 @@ type NetworkBuildkite struct {
 	Client *buildkite.Client
 	Org    string
 	Cache  Cache
+
+	// Concurrency bounds how many daily intervals are fetched from Buildkite
+	// at once. Defaults to 1 (sequential) when unset.
+	Concurrency int
+
+	// BuildStates selects which Buildkite build states to query for, e.g.
+	// "passed", "failed", "canceled", "blocked", "skipped". Defaults to
+	// []string{"passed"} when empty.
+	BuildStates []string
+
+	// RefreshMode controls how the current-day bucket is kept up to date.
+	// Defaults to RefreshFull.
+	RefreshMode RefreshMode
+
+	// Clock supplies the current time, defaulting to realClock. Overriding
+	// it makes the interval bucketing and TTL spread deterministic in tests.
+	Clock Clock
+
+	// Rand supplies randomness for the cache TTL spread, defaulting to
+	// realRand.
+	Rand Rand
+
+	// fetchGroup dedupes concurrent fetches for the same cache key, so that
+	// two goroutines racing on the same interval don't both hit the API.
+	fetchGroup singleflight.Group
+}
+
+// Clock abstracts time.Now so interval bucketing and cache TTLs can be
+// pinned in tests.
+type Clock interface {
+	Now() time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+// Rand abstracts the randomness used to spread out cache TTLs, so it can be
+// pinned in tests.
+type Rand interface {
+	Intn(n int) int
+}
+
+type realRand struct{}
+
+func (realRand) Intn(n int) int { return rand.Intn(n) }
+
+func (b *NetworkBuildkite) clock() Clock {
+	if b.Clock != nil {
+		return b.Clock
+	}
+	return realClock{}
+}
+
+func (b *NetworkBuildkite) rand() Rand {
+	if b.Rand != nil {
+		return b.Rand
+	}
+	return realRand{}
+}
+
+// RefreshMode controls how listBuildsBetween refreshes an interval whose
+// cache entry has a short TTL (i.e. one that overlaps the last 12h).
+type RefreshMode int
+
+const (
+	// RefreshFull re-fetches the whole interval from page 1 on every cache
+	// miss, as was always done before RefreshMode existed.
+	RefreshFull RefreshMode = iota
+
+	// RefreshIncremental only pages Buildkite forward from the highest
+	// CreatedAt seen in the existing cache entry, then merges the result
+	// into the cached slice. Falls back to RefreshFull when there's nothing
+	// cached yet for the interval.
+	//
+	// This only paging forward by CreatedAt is safe exactly because a
+	// build in a terminal state (passed/failed/canceled/skipped) never
+	// changes again. Paging forward alone would silently freeze the
+	// State/FinishedAt of any build cached while it was still in a
+	// non-terminal state (e.g. blocked or running), since it would never
+	// be refetched once the watermark moves past its CreatedAt. So
+	// RefreshIncremental only takes effect when BuildStates is entirely
+	// terminal states; otherwise listBuildsBetween falls back to
+	// RefreshFull for correctness.
+	RefreshIncremental
+)
+
+// terminalBuildStates are build states that, once reached, never change
+// again - the precondition RefreshIncremental relies on to page forward by
+// CreatedAt instead of re-checking every previously cached build.
+var terminalBuildStates = map[string]bool{
+	"passed":   true,
+	"failed":   true,
+	"canceled": true,
+	"skipped":  true,
+}
+
+// onlyTerminalBuildStates reports whether every state in BuildStates is a
+// terminal one, i.e. whether it's safe to use RefreshIncremental.
+func (b *NetworkBuildkite) onlyTerminalBuildStates() bool {
+	for _, state := range b.buildStates() {
+		if !terminalBuildStates[state] {
+			return false
+		}
+	}
+	return true
 }
 
 type Cache interface {
@@ -69,17 +195,42 @@ type Cache interface {
 const itemsPerPage = 100
 
 func (b *NetworkBuildkite) ListBuilds(from time.Time, pred BuildPredicate) ([]Build, error) {
-	to := time.Now()
+	to := b.clock().Now()
+	intervals := generateDailyIntervals(from, to)
+
+	concurrency := b.Concurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	g, ctx := errgroup.WithContext(context.Background())
+	g.SetLimit(concurrency)
+
+	results := make([][]Build, len(intervals))
+	for i, interval := range intervals {
+		i, interval := i, interval
+		g.Go(func() error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			log.Printf("Querying %+v...\n", interval)
+			bs, err := b.listBuildsBetween(ctx, interval, b.cacheTTL(interval))
+			if err != nil {
+				return err
+			}
+			results[i] = bs
+			return nil
+		})
+	}
+
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
 
 	var res []Build
-	for _, interval := range generateDailyIntervals(from, to) {
-		log.Printf("Querying %+v...\n", interval)
-		bs, err := b.listBuildsBetween(interval, cacheTTL(interval))
-		if err != nil {
-			return res, err
-		}
+	for _, bs := range results {
 		for _, b := range bs {
-			if b.CreatedAt.After(from) && b.CreatedAt.Before(to) && pred.Predicate(b) {
+			if b.CreatedAt != nil && b.CreatedAt.After(from) && b.CreatedAt.Before(to) && pred.Predicate(b) {
 				// Note that the daily intervals will be a superset of [to,
 				// from). This is to get the cached buckets static. This means
 				// that we need to do some filtering here.
@@ -91,16 +242,35 @@ func (b *NetworkBuildkite) ListBuilds(from time.Time, pred BuildPredicate) ([]Bu
 	return res, nil
 }
 
-func cacheTTL(interval timeInterval) time.Duration {
-	if time.Now().Sub(interval.To) > 12*time.Hour {
-		// Cache aggresively for older builds. We don't expect them to be
-		// modified. Use spread to not have to reload all builds at the
-		// same time.
-		spread := time.Duration(rand.Intn(7*24)) * time.Hour
-		return 60*24*time.Hour + spread
-	} else {
-		return 10 * time.Minute
+func (b *NetworkBuildkite) cacheTTL(interval timeInterval) time.Duration {
+	if !b.isRecentInterval(interval) {
+		return b.retentionTTL()
 	}
+	return 10 * time.Minute
+}
+
+// retentionTTL returns the long, spread-out TTL used to cache builds we
+// don't expect to change.
+//
+// It backs cacheTTL for non-recent intervals, and also backs the Cache.Put
+// call in refreshIncremental: a recent interval's cache entry there doubles
+// as the watermark refreshIncremental pages forward from on every call, and
+// that entry has to survive between calls (which can be spaced further
+// apart than 10 minutes) or every refresh
+// would degenerate into a full refetch as soon as the short TTL cacheTTL
+// uses for "is this still fresh enough to serve without refresh" lapsed.
+func (b *NetworkBuildkite) retentionTTL() time.Duration {
+	// Use spread to not have to reload all builds at the same time.
+	spread := time.Duration(b.rand().Intn(7*24)) * time.Hour
+	return 60*24*time.Hour + spread
+}
+
+// isRecentInterval reports whether interval falls within the window we
+// consider still "live", i.e. one whose builds can still change. Matches
+// the threshold cacheTTL uses to pick between the aggressive and the short
+// TTL.
+func (b *NetworkBuildkite) isRecentInterval(interval timeInterval) bool {
+	return b.clock().Now().Sub(interval.To) <= 12*time.Hour
 }
 
 type timeInterval struct {
@@ -110,38 +280,123 @@ type timeInterval struct {
 
 func generateDailyIntervals(from, to time.Time) []timeInterval {
 	startDay := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, time.Local)
-	endDay := startDay.Add(24 * time.Hour)
 
 	var res []timeInterval
 	for startDay.Before(to) {
+		// Recompute the next midnight via time.Date rather than adding a
+		// fixed 24h duration: on a DST transition day, local midnight to
+		// local midnight is 23 or 25 wall-clock hours, and Add(24*Hour)
+		// would drift the bucket boundary off midnight.
+		endDay := time.Date(startDay.Year(), startDay.Month(), startDay.Day()+1, 0, 0, 0, 0, time.Local)
 		res = append(res, timeInterval{startDay, endDay})
-		startDay, endDay = startDay.Add(24*time.Hour), endDay.Add(24*time.Hour)
+		startDay = endDay
 	}
 	return res
 }
 
-func (b *NetworkBuildkite) listBuildsBetween(interval timeInterval, cacheTTL time.Duration) ([]Build, error) {
+func (b *NetworkBuildkite) listBuildsBetween(ctx context.Context, interval timeInterval, cacheTTL time.Duration) ([]Build, error) {
+	start := time.Now()
+	ageBucket := intervalAgeBucket(b, interval)
+	defer func() {
+		listBuildsDuration.WithLabelValues(ageBucket).Observe(time.Since(start).Seconds())
+	}()
+
 	cacheKey := fmt.Sprintf("%d-%d", interval.From.Unix(), interval.To.Unix())
-	cached, err := b.readFromCache(cacheKey)
-	if err == nil {
-		return cached, err
+
+	// Dedupe concurrent fetches of the same interval across goroutines in
+	// the worker pool: only one of them hits the cache/API, the rest wait
+	// for and share its result.
+	v, err, _ := b.fetchGroup.Do(cacheKey, func() (interface{}, error) {
+		if b.RefreshMode == RefreshIncremental && b.isRecentInterval(interval) && b.onlyTerminalBuildStates() {
+			// Retain the entry by retentionTTL rather than the short
+			// cacheTTL passed in for this (recent) interval: see
+			// retentionTTL's doc comment for why.
+			return b.refreshIncremental(ctx, cacheKey, interval, b.retentionTTL())
+		}
+
+		cached, err := b.readFromCache(cacheKey, cacheTTL)
+		if err == nil {
+			return cached, nil
+		}
+
+		result, err := b.fetchBuildsBetween(ctx, interval.From, interval.To)
+		if err != nil {
+			return nil, err
+		}
+
+		_ = b.populateCache(cacheKey, result, cacheTTL)
+
+		return result, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return v.([]Build), nil
+}
+
+// refreshIncremental refreshes the cache entry for interval by only paging
+// Buildkite forward from the highest CreatedAt seen in the existing entry,
+// then merging the result into it (deduping by Build.ID). It falls back to
+// a full fetch of interval when there's nothing cached yet.
+//
+// ttl is the cache's retention TTL (see retentionTTL), not the short
+// freshness TTL cacheTTL would otherwise give this interval: the entry is
+// kept fresh by refreshIncremental re-running on every call, not by
+// expiring it, so it needs to outlive the gaps between those calls.
+//
+// Callers must only reach this when onlyTerminalBuildStates is true: every
+// build already in the cache is guaranteed final, so there's nothing to
+// re-check by paging forward from the watermark alone.
+func (b *NetworkBuildkite) refreshIncremental(ctx context.Context, cacheKey string, interval timeInterval, ttl time.Duration) ([]Build, error) {
+	cached, err := b.readFromCache(cacheKey, ttl)
+	if err != nil {
+		result, err := b.fetchBuildsBetween(ctx, interval.From, interval.To)
+		if err != nil {
+			return nil, err
+		}
+		_ = b.populateCache(cacheKey, result, ttl)
+		return result, nil
+	}
+
+	watermark := highestCreatedAt(cached)
+	if watermark.IsZero() {
+		watermark = interval.From
 	}
 
+	fresh, err := b.fetchBuildsBetween(ctx, watermark, interval.To)
+	if err != nil {
+		return nil, err
+	}
+
+	merged := mergeBuildsByID(cached, fresh)
+	_ = b.populateCache(cacheKey, merged, ttl)
+
+	return merged, nil
+}
+
+func (b *NetworkBuildkite) fetchBuildsBetween(ctx context.Context, from, to time.Time) ([]Build, error) {
 	opts := &buildkite.BuildsListOptions{
 		ListOptions: buildkite.ListOptions{
 			Page:    1,
 			PerPage: itemsPerPage,
 		},
-		CreatedFrom: interval.From,
-		CreatedTo:   interval.To,
+		CreatedFrom: from,
+		CreatedTo:   to,
 
-		// This implies that all `Build`s will have FinishedAt set.
-		State: []string{"passed"},
+		// Only passed builds are guaranteed to have FinishedAt set; callers
+		// requesting other states should nil-check timestamps on the
+		// returned Builds.
+		State: b.buildStates(),
 	}
 
 	var result []Build
 	for {
-		builds, resp, err := b.query(b.Org, opts)
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		builds, resp, err := b.queryWithRetry(ctx, b.Org, opts)
 		if err != nil {
 			return nil, err
 		}
@@ -154,13 +409,152 @@ func (b *NetworkBuildkite) listBuildsBetween(interval timeInterval, cacheTTL tim
 		opts.ListOptions.Page = resp.NextPage
 	}
 
-	_ = b.populateCache(cacheKey, result, cacheTTL)
-
 	return result, nil
 }
 
-func (b *NetworkBuildkite) query(org string, opts *buildkite.BuildsListOptions) ([]Build, *buildkite.Response, error) {
+// buildStates returns the build states to query for, defaulting to
+// []string{"passed"} when BuildStates is unset.
+func (b *NetworkBuildkite) buildStates() []string {
+	if len(b.BuildStates) > 0 {
+		return b.BuildStates
+	}
+	return []string{"passed"}
+}
+
+// highestCreatedAt returns the latest CreatedAt across builds, or the zero
+// time if builds is empty or none of them have CreatedAt set.
+func highestCreatedAt(builds []Build) time.Time {
+	var max time.Time
+	for _, build := range builds {
+		if build.CreatedAt != nil && build.CreatedAt.After(max) {
+			max = *build.CreatedAt
+		}
+	}
+	return max
+}
+
+// mergeBuildsByID combines existing and fresh, with fresh taking precedence
+// for any IDs present in both. The result is sorted by CreatedAt (falling
+// back to ID for builds without one, or to break ties) rather than left in
+// map iteration order, so that repeated calls against the same inputs -
+// e.g. across successive refreshIncremental runs - produce a stable slice.
+func mergeBuildsByID(existing, fresh []Build) []Build {
+	byID := make(map[string]Build, len(existing)+len(fresh))
+	for _, build := range existing {
+		byID[build.ID] = build
+	}
+	for _, build := range fresh {
+		byID[build.ID] = build
+	}
+
+	result := make([]Build, 0, len(byID))
+	for _, build := range byID {
+		result = append(result, build)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		ti, tj := result[i].CreatedAt, result[j].CreatedAt
+		switch {
+		case ti == nil && tj == nil:
+			return result[i].ID < result[j].ID
+		case ti == nil:
+			return true
+		case tj == nil:
+			return false
+		case !ti.Equal(*tj):
+			return ti.Before(*tj)
+		default:
+			return result[i].ID < result[j].ID
+		}
+	})
+
+	return result
+}
+
+// maxQueryAttempts bounds how many attempts in all (the first try included)
+// queryWithRetry makes at a single page fetch before giving up and returning
+// the last error.
+const maxQueryAttempts = 5
+
+// isRetryableStatus reports whether a response status code is worth
+// retrying: rate limiting and server-side errors are transient, but other
+// 4xx codes (bad auth, not found, ...) will never succeed on retry.
+func isRetryableStatus(statusCode int) bool {
+	return statusCode == http.StatusTooManyRequests || statusCode >= 500
+}
+
+// queryWithRetry wraps query with an exponential-backoff retry loop that
+// honours Buildkite's rate-limit response headers: if RateLimit-Remaining
+// hits zero, we sleep for Retry-After (falling back to the backoff delay if
+// the header is missing) before trying again. Non-retryable status codes
+// (e.g. 401/404) and context cancellation fail fast without exhausting the
+// retry budget.
+func (b *NetworkBuildkite) queryWithRetry(ctx context.Context, org string, opts *buildkite.BuildsListOptions) ([]Build, *buildkite.Response, error) {
+	backoff := time.Second
+
+	var (
+		builds []Build
+		resp   *buildkite.Response
+		err    error
+	)
+	for attempt := 1; attempt <= maxQueryAttempts; attempt++ {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, resp, ctxErr
+		}
+
+		builds, resp, err = b.query(ctx, org, opts)
+		if err == nil {
+			return builds, resp, nil
+		}
+
+		if resp != nil && !isRetryableStatus(resp.StatusCode) {
+			return nil, resp, err
+		}
+
+		if attempt == maxQueryAttempts {
+			break
+		}
+
+		wait := backoff
+		if resp != nil {
+			if resp.Header.Get("RateLimit-Remaining") == "0" {
+				if retryAfter, perr := strconv.Atoi(resp.Header.Get("Retry-After")); perr == nil {
+					wait = time.Duration(retryAfter) * time.Second
+				}
+			}
+		}
+
+		log.Printf("Buildkite API request failed (attempt %d/%d), retrying in %s: %v\n", attempt, maxQueryAttempts, wait, err)
+		select {
+		case <-ctx.Done():
+			return nil, resp, ctx.Err()
+		case <-time.After(wait):
+		}
+		backoff *= 2
+	}
+
+	return nil, resp, err
+}
+
+func (b *NetworkBuildkite) query(ctx context.Context, org string, opts *buildkite.BuildsListOptions) ([]Build, *buildkite.Response, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	start := time.Now()
 	bbuilds, resp, err := b.Client.Builds.ListByOrg(org, opts)
+	apiRequestDuration.Observe(time.Since(start).Seconds())
+
+	status := "ok"
+	if err != nil {
+		status = "error"
+	}
+	apiRequestsTotal.WithLabelValues(status).Inc()
+
+	if resp != nil {
+		recordRateLimitRemaining(resp.Header.Get("RateLimit-Remaining"))
+	}
+
 	if err != nil {
 		return nil, resp, err
 	}
@@ -174,61 +568,35 @@ func (b *NetworkBuildkite) query(org string, opts *buildkite.BuildsListOptions)
 }
 
 func (b *NetworkBuildkite) populateCache(key string, builds []Build, ttl time.Duration) error {
-	s, err := json.Marshal(builds)
+	s, err := encodeCacheBlob(builds)
 	if err != nil {
 		log.Panicln(err)
 	}
 
-	// Compressing to make this a bit more future proof in case we have a _lot_
-	// of builds per key one day - memcache keys usually can't be larger than 1
-	// MB. We could of course switch to serialize to something like less
-	// verbose like protobuf, but let's keep it simple for now.
-	s = compress(s)
-
 	return b.Cache.Put(key, s, ttl)
 }
 
-func (b *NetworkBuildkite) readFromCache(key string) ([]Build, error) {
-	var res []Build
+func (b *NetworkBuildkite) readFromCache(key string, ttl time.Duration) ([]Build, error) {
 	s, err := b.Cache.Get(key)
 	if err != nil {
-		return res, err
+		cacheMissesTotal.Inc()
+		return nil, err
 	}
+	cacheHitsTotal.Inc()
 
-	s = decompress(s)
-
-	err = json.Unmarshal(s, &res)
+	res, legacy, err := decodeCacheBlob(s)
 	if err != nil {
 		log.Panicln(err)
 	}
 
-	return res, nil
-}
-
-func compress(b []byte) []byte {
-	input := bytes.NewBuffer(b)
-	output := bytes.NewBuffer(nil)
-	r := gzip.NewWriter(output)
-	_, _ = io.Copy(r, input)
-	_ = r.Close()
-	return output.Bytes()
-}
-
-func decompress(b []byte) []byte {
-	input := bytes.NewBuffer(b)
-	output := bytes.NewBuffer(nil)
-	var err error
-	r, err := gzip.NewReader(input)
-	if err != nil {
-		log.Panicln("unable to create gzip reader:", err)
-	}
-	_, err = io.Copy(output, r)
-	if err != nil {
-		log.Panicln("unable to decompress:", err)
-	}
-	err = r.Close()
-	if err != nil {
-		log.Panicln("unable to Close when decompressing:", err)
+	if legacy {
+		// Transparently upgrade old gzip+JSON blobs by rewriting them in the
+		// current format right away, so existing caches don't need to be
+		// flushed.
+		if reencoded, err := encodeCacheBlob(res); err == nil {
+			_ = b.Cache.Put(key, reencoded, ttl)
+		}
 	}
-	return output.Bytes()
+
+	return res, nil
 }
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/buildkite/go-buildkite/buildkite"
+)
+
+// newTestBuildkiteClient returns a *buildkite.Client pointed at an
+// httptest.Server running handler, so query/queryWithRetry/ListBuilds can be
+// exercised against canned HTTP responses instead of the real API.
+func newTestBuildkiteClient(t *testing.T, handler http.Handler) *buildkite.Client {
+	t.Helper()
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	client := buildkite.NewClient(server.Client())
+	u, err := url.Parse(server.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.BaseURL = u
+	return client
+}
+
+// withLocal temporarily overrides time.Local for the duration of fn, since
+// generateDailyIntervals buckets days in time.Local and the test process's
+// default zone (usually UTC) has no DST transitions to exercise.
+func withLocal(t *testing.T, loc *time.Location, fn func()) {
+	t.Helper()
+	old := time.Local
+	time.Local = loc
+	defer func() { time.Local = old }()
+	fn()
+}
+
+func TestGenerateDailyIntervals_MonthRollover(t *testing.T) {
+	withLocal(t, time.UTC, func() {
+		from := time.Date(2024, time.January, 30, 15, 0, 0, 0, time.Local)
+		to := time.Date(2024, time.February, 2, 9, 0, 0, 0, time.Local)
+
+		intervals := generateDailyIntervals(from, to)
+
+		wantStarts := []time.Time{
+			time.Date(2024, time.January, 30, 0, 0, 0, 0, time.Local),
+			time.Date(2024, time.January, 31, 0, 0, 0, 0, time.Local),
+			time.Date(2024, time.February, 1, 0, 0, 0, 0, time.Local),
+			time.Date(2024, time.February, 2, 0, 0, 0, 0, time.Local),
+		}
+		if len(intervals) != len(wantStarts) {
+			t.Fatalf("got %d intervals, want %d: %+v", len(intervals), len(wantStarts), intervals)
+		}
+		for i, want := range wantStarts {
+			if !intervals[i].From.Equal(want) {
+				t.Errorf("interval %d: From = %v, want %v", i, intervals[i].From, want)
+			}
+			if !intervals[i].To.Equal(want.Add(24 * time.Hour)) {
+				t.Errorf("interval %d: To = %v, want %v", i, intervals[i].To, want.Add(24*time.Hour))
+			}
+		}
+	})
+}
+
+func TestGenerateDailyIntervals_DSTSpringForward(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("tzdata unavailable: %v", err)
+	}
+
+	withLocal(t, loc, func() {
+		// 2024-03-10 is the US spring-forward DST transition (clocks skip
+		// from 02:00 to 03:00), so local midnight to local midnight that
+		// day spans only 23 wall-clock hours. generateDailyIntervals must
+		// still produce one bucket per calendar day, not per 24h.
+		from := time.Date(2024, time.March, 9, 12, 0, 0, 0, time.Local)
+		to := time.Date(2024, time.March, 11, 12, 0, 0, 0, time.Local)
+
+		intervals := generateDailyIntervals(from, to)
+
+		wantStarts := []time.Time{
+			time.Date(2024, time.March, 9, 0, 0, 0, 0, time.Local),
+			time.Date(2024, time.March, 10, 0, 0, 0, 0, time.Local),
+			time.Date(2024, time.March, 11, 0, 0, 0, 0, time.Local),
+		}
+		if len(intervals) != len(wantStarts) {
+			t.Fatalf("got %d intervals, want %d: %+v", len(intervals), len(wantStarts), intervals)
+		}
+		for i, want := range wantStarts {
+			if !intervals[i].From.Equal(want) {
+				t.Errorf("interval %d: From = %v, want %v", i, intervals[i].From, want)
+			}
+		}
+	})
+}
+
+func TestGenerateDailyIntervals_DSTFallBack(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("tzdata unavailable: %v", err)
+	}
+
+	withLocal(t, loc, func() {
+		// 2024-11-03 is the US fall-back DST transition (01:00-02:00 occurs
+		// twice), so local midnight to local midnight spans 25 wall-clock
+		// hours. Still exactly one bucket for that calendar day.
+		from := time.Date(2024, time.November, 2, 12, 0, 0, 0, time.Local)
+		to := time.Date(2024, time.November, 4, 12, 0, 0, 0, time.Local)
+
+		intervals := generateDailyIntervals(from, to)
+
+		wantStarts := []time.Time{
+			time.Date(2024, time.November, 2, 0, 0, 0, 0, time.Local),
+			time.Date(2024, time.November, 3, 0, 0, 0, 0, time.Local),
+			time.Date(2024, time.November, 4, 0, 0, 0, 0, time.Local),
+		}
+		if len(intervals) != len(wantStarts) {
+			t.Fatalf("got %d intervals, want %d: %+v", len(intervals), len(wantStarts), intervals)
+		}
+		for i, want := range wantStarts {
+			if !intervals[i].From.Equal(want) {
+				t.Errorf("interval %d: From = %v, want %v", i, intervals[i].From, want)
+			}
+		}
+	})
+}
+
+// fixedClock is a Clock that always returns the same instant.
+type fixedClock struct{ now time.Time }
+
+func (c fixedClock) Now() time.Time { return c.now }
+
+// fixedRand is a Rand that always returns the same value.
+type fixedRand struct{ n int }
+
+func (r fixedRand) Intn(int) int { return r.n }
+
+func TestNetworkBuildkite_cacheTTL(t *testing.T) {
+	now := time.Date(2024, time.June, 15, 12, 0, 0, 0, time.UTC)
+	b := &NetworkBuildkite{
+		Clock: fixedClock{now: now},
+		Rand:  fixedRand{n: 3},
+	}
+
+	recent := timeInterval{From: now.Add(-time.Hour), To: now.Add(-30 * time.Minute)}
+	if got, want := b.cacheTTL(recent), 10*time.Minute; got != want {
+		t.Errorf("cacheTTL(recent) = %v, want %v", got, want)
+	}
+
+	old := timeInterval{From: now.Add(-48 * time.Hour), To: now.Add(-24 * time.Hour)}
+	if got, want := b.cacheTTL(old), 60*24*time.Hour+3*time.Hour; got != want {
+		t.Errorf("cacheTTL(old) = %v, want %v", got, want)
+	}
+}
+
+func TestQueryWithRetry_RetriesOnServerError(t *testing.T) {
+	var calls int32
+	client := newTestBuildkiteClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `[]`)
+	}))
+
+	b := &NetworkBuildkite{Client: client, Org: "acme"}
+	opts := &buildkite.BuildsListOptions{ListOptions: buildkite.ListOptions{Page: 1, PerPage: itemsPerPage}}
+
+	if _, _, err := b.queryWithRetry(context.Background(), "acme", opts); err != nil {
+		t.Fatalf("queryWithRetry() error = %v", err)
+	}
+	if got := atomic.LoadInt32(&calls); got != 2 {
+		t.Errorf("calls = %d, want 2 (one failure, one retry)", got)
+	}
+}
+
+func TestQueryWithRetry_FailsFastOnNonRetryableStatus(t *testing.T) {
+	var calls int32
+	client := newTestBuildkiteClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusNotFound)
+	}))
+
+	b := &NetworkBuildkite{Client: client, Org: "acme"}
+	opts := &buildkite.BuildsListOptions{ListOptions: buildkite.ListOptions{Page: 1, PerPage: itemsPerPage}}
+
+	if _, _, err := b.queryWithRetry(context.Background(), "acme", opts); err == nil {
+		t.Fatal("queryWithRetry() error = nil, want non-nil")
+	}
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Errorf("calls = %d, want 1 (404 should fail fast, not retry)", got)
+	}
+}
+
+func TestListBuildsBetween_DedupesConcurrentFetches(t *testing.T) {
+	created := time.Now().Add(-time.Hour)
+
+	var calls int32
+	client := newTestBuildkiteClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(50 * time.Millisecond)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `[{"id":"b1","pipeline":{"name":"p"},"branch":"main","state":"passed","created_at":%q}]`, created.Format(time.RFC3339))
+	}))
+
+	b := &NetworkBuildkite{
+		Client: client,
+		Org:    "acme",
+		Cache:  NewMemoryCache(),
+	}
+	interval := timeInterval{From: created.Add(-time.Hour), To: created.Add(time.Hour)}
+
+	const goroutines = 5
+	results := make([][]Build, goroutines)
+	var wg sync.WaitGroup
+	for i := range results {
+		i := i
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			bs, err := b.listBuildsBetween(context.Background(), interval, time.Minute)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			results[i] = bs
+		}()
+	}
+	wg.Wait()
+
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Errorf("API calls = %d, want 1 (concurrent fetches of the same interval should be deduped via fetchGroup)", got)
+	}
+	for i, bs := range results {
+		if len(bs) != 1 || bs[0].ID != "b1" {
+			t.Errorf("results[%d] = %+v, want one build b1", i, bs)
+		}
+	}
+}
+
+// fakeCache is a Cache that records the ttl passed to the most recent Put
+// per key, so tests can assert on it without waiting out real TTLs.
+type fakeCache struct {
+	mu      sync.Mutex
+	values  map[string][]byte
+	lastTTL map[string]time.Duration
+}
+
+func newFakeCache() *fakeCache {
+	return &fakeCache{values: map[string][]byte{}, lastTTL: map[string]time.Duration{}}
+}
+
+func (c *fakeCache) Put(k string, v []byte, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[k] = v
+	c.lastTTL[k] = ttl
+	return nil
+}
+
+func (c *fakeCache) Get(k string) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	v, ok := c.values[k]
+	if !ok {
+		return nil, ErrCacheMiss
+	}
+	return v, nil
+}
+
+func TestRefreshIncremental_RetainsEntryPastShortFreshnessTTL(t *testing.T) {
+	created := time.Now().Add(-time.Minute)
+
+	client := newTestBuildkiteClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `[{"id":"b1","pipeline":{"name":"p"},"branch":"main","state":"passed","created_at":%q}]`, created.Format(time.RFC3339))
+	}))
+
+	cache := newFakeCache()
+	b := &NetworkBuildkite{
+		Client:      client,
+		Org:         "acme",
+		Cache:       cache,
+		BuildStates: []string{"passed"},
+	}
+	interval := timeInterval{From: created.Add(-time.Hour), To: created.Add(time.Hour)}
+	cacheKey := fmt.Sprintf("%d-%d", interval.From.Unix(), interval.To.Unix())
+
+	if _, err := b.refreshIncremental(context.Background(), cacheKey, interval, b.retentionTTL()); err != nil {
+		t.Fatalf("refreshIncremental() error = %v", err)
+	}
+
+	cache.mu.Lock()
+	gotTTL := cache.lastTTL[cacheKey]
+	cache.mu.Unlock()
+
+	if gotTTL <= 10*time.Minute {
+		t.Errorf("refreshIncremental() populated the cache with ttl %v, want the long retention TTL (> 10m) so the watermark survives between incremental refreshes", gotTTL)
+	}
+}
+
+func TestMergeBuildsByID(t *testing.T) {
+	t1 := time.Date(2024, time.June, 15, 10, 0, 0, 0, time.UTC)
+	t2 := time.Date(2024, time.June, 15, 11, 0, 0, 0, time.UTC)
+	t3 := time.Date(2024, time.June, 15, 12, 0, 0, 0, time.UTC)
+
+	existing := []Build{
+		{ID: "b2", State: "running", CreatedAt: &t2},
+		{ID: "b3", CreatedAt: &t3},
+	}
+	fresh := []Build{
+		{ID: "b1", CreatedAt: &t1},
+		{ID: "b2", State: "passed", CreatedAt: &t2},
+	}
+
+	for run := 0; run < 10; run++ {
+		got := mergeBuildsByID(existing, fresh)
+
+		if len(got) != 3 {
+			t.Fatalf("len(got) = %d, want 3: %+v", len(got), got)
+		}
+		wantIDs := []string{"b1", "b2", "b3"}
+		for i, want := range wantIDs {
+			if got[i].ID != want {
+				t.Fatalf("got[%d].ID = %q, want %q (run %d): %+v", i, got[i].ID, want, run, got)
+			}
+		}
+		if got[1].State != "passed" {
+			t.Errorf("got[1].State = %q, want %q (fresh should win)", got[1].State, "passed")
+		}
+	}
+}
+
+func TestNetworkBuildkite_onlyTerminalBuildStates(t *testing.T) {
+	tests := []struct {
+		name   string
+		states []string
+		want   bool
+	}{
+		{"default (unset)", nil, true},
+		{"all terminal", []string{"passed", "failed", "canceled", "skipped"}, true},
+		{"includes blocked", []string{"passed", "blocked"}, false},
+		{"includes running", []string{"running"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &NetworkBuildkite{BuildStates: tt.states}
+			if got := b.onlyTerminalBuildStates(); got != tt.want {
+				t.Errorf("onlyTerminalBuildStates() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
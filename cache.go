@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// ErrCacheMiss is returned by a Cache backend's Get when the key is absent
+// or has expired. Callers (readFromCache) treat any non-nil error as a
+// cache miss, but backends should use this sentinel where possible so
+// behaviour is consistent across backends.
+var ErrCacheMiss = errors.New("cache: key not found")
+
+// NewCacheBackend builds a Cache from a backend URL, e.g.:
+//
+//	bolt://./cache.db
+//	redis://localhost:6379/0
+//	file:///var/cache/buildkite-stats
+//
+// An empty rawurl returns an in-process MemoryCache, which is fine for
+// one-off runs but does not survive restarts.
+func NewCacheBackend(rawurl string) (Cache, error) {
+	if rawurl == "" {
+		return NewMemoryCache(), nil
+	}
+
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, fmt.Errorf("parsing cache backend url %q: %w", rawurl, err)
+	}
+
+	switch u.Scheme {
+	case "bolt":
+		return newBoltCache(u.Host + u.Path)
+	case "redis":
+		return newRedisCache(u)
+	case "file":
+		return newFileCache(u.Host + u.Path)
+	default:
+		return nil, fmt.Errorf("unknown cache backend %q", u.Scheme)
+	}
+}
+
+// MemoryCache is the default Cache backend: an in-process map with no
+// persistence across restarts. Useful for tests and one-off runs.
+type MemoryCache struct {
+	mu      sync.Mutex
+	entries map[string]memoryCacheEntry
+}
+
+type memoryCacheEntry struct {
+	value     []byte
+	expiresAt time.Time
+}
+
+func NewMemoryCache() *MemoryCache {
+	return &MemoryCache{entries: make(map[string]memoryCacheEntry)}
+}
+
+func (c *MemoryCache) Put(k string, v []byte, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[k] = memoryCacheEntry{value: v, expiresAt: time.Now().Add(ttl)}
+	return nil
+}
+
+func (c *MemoryCache) Get(k string) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[k]
+	if !ok || time.Now().After(entry.expiresAt) {
+		return nil, ErrCacheMiss
+	}
+	return entry.value, nil
+}
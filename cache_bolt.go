@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// boltCacheBucket is the bucket builds are stored in, keyed by the same
+// "from-to" unix timestamp key used by the other Cache backends.
+var boltCacheBucket = []byte("object-cache")
+
+// BoltCache is an on-disk Cache backend backed by a single BoltDB file, so
+// long-running deployments can persist the cache across restarts without
+// memcache.
+type BoltCache struct {
+	db *bolt.DB
+}
+
+type boltCacheEntry struct {
+	ExpiresAt time.Time
+	Value     []byte
+}
+
+func newBoltCache(path string) (*BoltCache, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltCacheBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &BoltCache{db: db}, nil
+}
+
+func (c *BoltCache) Put(k string, v []byte, ttl time.Duration) error {
+	buf, err := json.Marshal(boltCacheEntry{ExpiresAt: time.Now().Add(ttl), Value: v})
+	if err != nil {
+		return err
+	}
+
+	return c.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltCacheBucket).Put([]byte(k), buf)
+	})
+}
+
+func (c *BoltCache) Get(k string) ([]byte, error) {
+	var buf []byte
+	err := c.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(boltCacheBucket).Get([]byte(k))
+		if v == nil {
+			return ErrCacheMiss
+		}
+		buf = append([]byte(nil), v...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var entry boltCacheEntry
+	if err := json.Unmarshal(buf, &entry); err != nil {
+		return nil, err
+	}
+	if time.Now().After(entry.ExpiresAt) {
+		return nil, ErrCacheMiss
+	}
+
+	return entry.Value, nil
+}
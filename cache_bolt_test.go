@@ -0,0 +1,54 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestBoltCache(t *testing.T) *BoltCache {
+	t.Helper()
+
+	c, err := newBoltCache(filepath.Join(t.TempDir(), "cache.db"))
+	if err != nil {
+		t.Fatalf("newBoltCache() error = %v", err)
+	}
+	t.Cleanup(func() { c.db.Close() })
+	return c
+}
+
+func TestBoltCache_RoundTrip(t *testing.T) {
+	c := newTestBoltCache(t)
+
+	if err := c.Put("k", []byte("v"), time.Minute); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+
+	got, err := c.Get("k")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if string(got) != "v" {
+		t.Errorf("Get() = %q, want %q", got, "v")
+	}
+}
+
+func TestBoltCache_Miss(t *testing.T) {
+	c := newTestBoltCache(t)
+
+	if _, err := c.Get("missing"); err != ErrCacheMiss {
+		t.Errorf("Get() error = %v, want ErrCacheMiss", err)
+	}
+}
+
+func TestBoltCache_Expiry(t *testing.T) {
+	c := newTestBoltCache(t)
+
+	if err := c.Put("k", []byte("v"), -time.Second); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+
+	if _, err := c.Get("k"); err != ErrCacheMiss {
+		t.Errorf("Get() error = %v, want ErrCacheMiss for an expired entry", err)
+	}
+}
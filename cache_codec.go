@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// cacheMagic identifies a blob written by encodeCacheBlob, followed by a
+// single version byte. Blobs without this magic are assumed to be the
+// legacy gzip+JSON format and are decoded accordingly.
+var cacheMagic = [3]byte{'B', 'K', 'C'}
+
+const cacheFormatVersion = 1
+
+// encodeCacheBlob serializes builds as msgpack and compresses the result
+// with zstd, prefixed with a 4-byte magic+version header so future formats
+// can be distinguished from this one (and from the legacy gzip+JSON blobs).
+func encodeCacheBlob(builds []Build) ([]byte, error) {
+	payload, err := msgpack.Marshal(builds)
+	if err != nil {
+		return nil, fmt.Errorf("marshaling builds: %w", err)
+	}
+
+	compressed := zstdCompress(payload)
+
+	buf := make([]byte, 0, 4+len(compressed))
+	buf = append(buf, cacheMagic[:]...)
+	buf = append(buf, cacheFormatVersion)
+	buf = append(buf, compressed...)
+	return buf, nil
+}
+
+// decodeCacheBlob decodes a blob written by encodeCacheBlob, or transparently
+// falls back to the legacy gzip+JSON format, reporting via the second return
+// value whether the legacy path was taken so the caller can rewrite the
+// cache entry in the current format.
+func decodeCacheBlob(b []byte) (builds []Build, legacy bool, err error) {
+	if isLegacyBlob(b) {
+		builds, err = decodeLegacyCacheBlob(b)
+		return builds, true, err
+	}
+
+	if len(b) < 4 || b[0] != cacheMagic[0] || b[1] != cacheMagic[1] || b[2] != cacheMagic[2] {
+		return nil, false, fmt.Errorf("cache blob missing magic header")
+	}
+
+	version := b[3]
+	if version != cacheFormatVersion {
+		return nil, false, fmt.Errorf("unsupported cache format version %d", version)
+	}
+
+	payload, err := zstdDecompress(b[4:])
+	if err != nil {
+		return nil, false, fmt.Errorf("decompressing cache blob: %w", err)
+	}
+
+	if err := msgpack.Unmarshal(payload, &builds); err != nil {
+		return nil, false, fmt.Errorf("unmarshaling builds: %w", err)
+	}
+
+	return builds, false, nil
+}
+
+// isLegacyBlob sniffs for the gzip magic number, since the pre-msgpack
+// format was plain JSON wrapped in gzip with no header of its own.
+func isLegacyBlob(b []byte) bool {
+	return len(b) >= 2 && b[0] == 0x1f && b[1] == 0x8b
+}
+
+func decodeLegacyCacheBlob(b []byte) ([]Build, error) {
+	r, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, fmt.Errorf("unable to create gzip reader: %w", err)
+	}
+	defer r.Close()
+
+	payload, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decompress: %w", err)
+	}
+
+	var builds []Build
+	if err := json.Unmarshal(payload, &builds); err != nil {
+		return nil, fmt.Errorf("unmarshaling legacy builds: %w", err)
+	}
+
+	return builds, nil
+}
+
+var zstdEncoders = sync.Pool{
+	New: func() interface{} {
+		enc, err := zstd.NewWriter(nil)
+		if err != nil {
+			panic(err)
+		}
+		return enc
+	},
+}
+
+var zstdDecoders = sync.Pool{
+	New: func() interface{} {
+		dec, err := zstd.NewReader(nil)
+		if err != nil {
+			panic(err)
+		}
+		return dec
+	},
+}
+
+func zstdCompress(b []byte) []byte {
+	enc := zstdEncoders.Get().(*zstd.Encoder)
+	defer zstdEncoders.Put(enc)
+	return enc.EncodeAll(b, nil)
+}
+
+func zstdDecompress(b []byte) ([]byte, error) {
+	dec := zstdDecoders.Get().(*zstd.Decoder)
+	defer zstdDecoders.Put(dec)
+	return dec.DecodeAll(b, nil)
+}
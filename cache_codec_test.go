@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// sampleBuilds returns n synthetic builds roughly representative of what a
+// busy daily interval's cache entry looks like.
+func sampleBuilds(n int) []Build {
+	builds := make([]Build, n)
+	for i := range builds {
+		created := time.Now().Add(-time.Duration(i) * time.Minute)
+		builds[i] = Build{
+			ID: fmt.Sprintf("build-%d", i),
+			Pipeline: Pipeline{
+				Name: "some-pipeline",
+			},
+			Branch:      "main",
+			State:       "passed",
+			CreatedAt:   &created,
+			StartedAt:   &created,
+			ScheduledAt: &created,
+			FinishedAt:  &created,
+		}
+	}
+	return builds
+}
+
+// legacyEncode reproduces the old gzip+JSON cache blob format, for
+// comparison against encodeCacheBlob's msgpack+zstd format.
+func legacyEncode(b *testing.B, builds []Build) []byte {
+	b.Helper()
+
+	s, err := json.Marshal(builds)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(s); err != nil {
+		b.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		b.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func BenchmarkEncodeCacheBlob(b *testing.B) {
+	builds := sampleBuilds(1000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := encodeCacheBlob(builds); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEncodeCacheBlobLegacyGzipJSON(b *testing.B) {
+	builds := sampleBuilds(1000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		legacyEncode(b, builds)
+	}
+}
+
+func BenchmarkDecodeCacheBlob(b *testing.B) {
+	builds := sampleBuilds(1000)
+	blob, err := encodeCacheBlob(builds)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := decodeCacheBlob(blob); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecodeCacheBlobLegacyGzipJSON(b *testing.B) {
+	builds := sampleBuilds(1000)
+	blob := legacyEncode(b, builds)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := decodeCacheBlob(blob); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// TestCacheBlobSize reports the encoded size of both formats side by side,
+// since benchmarks don't surface output size on their own.
+func TestCacheBlobSize(t *testing.T) {
+	builds := sampleBuilds(1000)
+
+	newBlob, err := encodeCacheBlob(builds)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := json.Marshal(builds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(s); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Logf("msgpack+zstd: %d bytes, legacy gzip+JSON: %d bytes", len(newBlob), buf.Len())
+}
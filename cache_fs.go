@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/binary"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// FileCache is a Cache backend that writes one blob per key to a directory
+// on disk, for deployments that want persistence without running a
+// separate cache service.
+type FileCache struct {
+	dir string
+}
+
+func newFileCache(dir string) (*FileCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &FileCache{dir: dir}, nil
+}
+
+func (c *FileCache) path(k string) string {
+	return filepath.Join(c.dir, url.PathEscape(k)+".cache")
+}
+
+// Put prefixes the blob with an 8-byte big-endian expiry timestamp, since
+// the filesystem has no native TTL support.
+func (c *FileCache) Put(k string, v []byte, ttl time.Duration) error {
+	buf := make([]byte, 8+len(v))
+	binary.BigEndian.PutUint64(buf[:8], uint64(time.Now().Add(ttl).Unix()))
+	copy(buf[8:], v)
+
+	return os.WriteFile(c.path(k), buf, 0644)
+}
+
+func (c *FileCache) Get(k string) ([]byte, error) {
+	buf, err := os.ReadFile(c.path(k))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrCacheMiss
+		}
+		return nil, err
+	}
+	if len(buf) < 8 {
+		return nil, ErrCacheMiss
+	}
+
+	expiresAt := int64(binary.BigEndian.Uint64(buf[:8]))
+	if time.Now().Unix() > expiresAt {
+		_ = os.Remove(c.path(k))
+		return nil, ErrCacheMiss
+	}
+
+	return buf[8:], nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestFileCache(t *testing.T) *FileCache {
+	t.Helper()
+
+	c, err := newFileCache(t.TempDir())
+	if err != nil {
+		t.Fatalf("newFileCache() error = %v", err)
+	}
+	return c
+}
+
+func TestFileCache_RoundTrip(t *testing.T) {
+	c := newTestFileCache(t)
+
+	if err := c.Put("k", []byte("v"), time.Minute); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+
+	got, err := c.Get("k")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if string(got) != "v" {
+		t.Errorf("Get() = %q, want %q", got, "v")
+	}
+}
+
+func TestFileCache_Miss(t *testing.T) {
+	c := newTestFileCache(t)
+
+	if _, err := c.Get("missing"); err != ErrCacheMiss {
+		t.Errorf("Get() error = %v, want ErrCacheMiss", err)
+	}
+}
+
+// TestFileCache_Expiry exercises the 8-byte big-endian expiry prefix Put
+// packs in front of the value: an expired entry must miss, not return the
+// prefix bytes as part of the value.
+func TestFileCache_Expiry(t *testing.T) {
+	c := newTestFileCache(t)
+
+	if err := c.Put("k", []byte("v"), -time.Second); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+
+	if _, err := c.Get("k"); err != ErrCacheMiss {
+		t.Errorf("Get() error = %v, want ErrCacheMiss for an expired entry", err)
+	}
+}
+
+func TestFileCache_KeysAreEscapedForThePath(t *testing.T) {
+	c := newTestFileCache(t)
+
+	// Cache keys are "<unix>-<unix>" (see listBuildsBetween), but nothing
+	// stops an arbitrary key containing path separators from reaching Put,
+	// so it must not be able to escape c.dir.
+	key := "../../etc/passwd"
+	if err := c.Put(key, []byte("v"), time.Minute); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+
+	got, err := c.Get(key)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if string(got) != "v" {
+		t.Errorf("Get() = %q, want %q", got, "v")
+	}
+	if dir := filepath.Dir(c.path(key)); dir != c.dir {
+		t.Errorf("path(%q) is in %q, want it directly in %q", key, dir, c.dir)
+	}
+}
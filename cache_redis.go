@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// RedisCache is a Cache backend for sharing the cache across multiple
+// buildkite-stats instances, or persisting it across restarts.
+type RedisCache struct {
+	client *redis.Client
+}
+
+func newRedisCache(u *url.URL) (*RedisCache, error) {
+	opts, err := redis.ParseURL(u.String())
+	if err != nil {
+		return nil, err
+	}
+
+	return &RedisCache{client: redis.NewClient(opts)}, nil
+}
+
+func (c *RedisCache) Put(k string, v []byte, ttl time.Duration) error {
+	return c.client.Set(context.Background(), k, v, ttl).Err()
+}
+
+func (c *RedisCache) Get(k string) ([]byte, error) {
+	v, err := c.client.Get(context.Background(), k).Bytes()
+	if err == redis.Nil {
+		return nil, ErrCacheMiss
+	}
+	return v, err
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// newTestRedisCache skips the test unless a Redis instance is reachable at
+// localhost:6379, since this repo doesn't bundle a fake Redis server.
+func newTestRedisCache(t *testing.T) *RedisCache {
+	t.Helper()
+
+	conn, err := net.DialTimeout("tcp", "localhost:6379", 200*time.Millisecond)
+	if err != nil {
+		t.Skipf("no redis reachable at localhost:6379: %v", err)
+	}
+	conn.Close()
+
+	u, err := url.Parse("redis://localhost:6379/0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := newRedisCache(u)
+	if err != nil {
+		t.Fatalf("newRedisCache() error = %v", err)
+	}
+	t.Cleanup(func() { c.client.Close() })
+	return c
+}
+
+func TestRedisCache_RoundTrip(t *testing.T) {
+	c := newTestRedisCache(t)
+	key := "buildkite-stats-test-roundtrip"
+	t.Cleanup(func() { c.client.Del(context.Background(), key) })
+
+	if err := c.Put(key, []byte("v"), time.Minute); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+
+	got, err := c.Get(key)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if string(got) != "v" {
+		t.Errorf("Get() = %q, want %q", got, "v")
+	}
+}
+
+func TestRedisCache_Miss(t *testing.T) {
+	c := newTestRedisCache(t)
+
+	if _, err := c.Get("buildkite-stats-test-missing"); err != ErrCacheMiss {
+		t.Errorf("Get() error = %v, want ErrCacheMiss", err)
+	}
+}
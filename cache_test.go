@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryCache_RoundTrip(t *testing.T) {
+	c := NewMemoryCache()
+
+	if err := c.Put("k", []byte("v"), time.Minute); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+
+	got, err := c.Get("k")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if string(got) != "v" {
+		t.Errorf("Get() = %q, want %q", got, "v")
+	}
+}
+
+func TestMemoryCache_Miss(t *testing.T) {
+	c := NewMemoryCache()
+
+	if _, err := c.Get("missing"); err != ErrCacheMiss {
+		t.Errorf("Get() error = %v, want ErrCacheMiss", err)
+	}
+}
+
+func TestMemoryCache_Expiry(t *testing.T) {
+	c := NewMemoryCache()
+
+	if err := c.Put("k", []byte("v"), -time.Second); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+
+	if _, err := c.Get("k"); err != ErrCacheMiss {
+		t.Errorf("Get() error = %v, want ErrCacheMiss for an expired entry", err)
+	}
+}
+
+func TestNewCacheBackend(t *testing.T) {
+	c, err := NewCacheBackend("")
+	if err != nil {
+		t.Fatalf("NewCacheBackend(\"\") error = %v", err)
+	}
+	if _, ok := c.(*MemoryCache); !ok {
+		t.Errorf("NewCacheBackend(\"\") = %T, want *MemoryCache", c)
+	}
+
+	if _, err := NewCacheBackend("carrier-pigeon://nest"); err == nil {
+		t.Error("NewCacheBackend() with an unknown scheme: error = nil, want non-nil")
+	}
+}
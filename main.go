@@ -0,0 +1,101 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/buildkite/go-buildkite/buildkite"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+func main() {
+	var (
+		org         = flag.String("org", "", "Buildkite organization slug")
+		token       = flag.String("token", os.Getenv("BUILDKITE_API_TOKEN"), "Buildkite API token")
+		cacheAddr   = flag.String("cache", "", "cache backend: bolt://./cache.db, redis://localhost:6379, file:///var/cache/buildkite-stats (default: in-memory)")
+		since       = flag.Duration("since", 30*24*time.Hour, "how far back to fetch builds")
+		metricsAddr = flag.String("metrics-addr", "", "address to serve Prometheus metrics on, e.g. :9090 (disabled if empty)")
+		states      = flag.String("states", "passed", "comma-separated Buildkite build states to fetch, e.g. passed,failed,canceled")
+		concurrency = flag.Int("concurrency", 1, "how many daily intervals to fetch from Buildkite at once")
+		incremental = flag.Bool("incremental", false, "only page forward from the cached watermark for recent intervals instead of refetching them in full (requires all --states to be terminal states)")
+	)
+	flag.Parse()
+
+	if *metricsAddr != "" {
+		go serveMetrics(*metricsAddr)
+	}
+
+	buildStates := strings.Split(*states, ",")
+
+	config, err := buildkite.NewTokenConfig(*token, false)
+	if err != nil {
+		log.Fatalf("buildkite: %v", err)
+	}
+	config.Transport = &rateLimitRoundTripper{}
+
+	cache, err := NewCacheBackend(*cacheAddr)
+	if err != nil {
+		log.Fatalf("cache: %v", err)
+	}
+
+	refreshMode := RefreshFull
+	if *incremental {
+		refreshMode = RefreshIncremental
+	}
+
+	bk := &NetworkBuildkite{
+		Client:      buildkite.NewClient(config.Client()),
+		Org:         *org,
+		Cache:       cache,
+		Concurrency: *concurrency,
+		BuildStates: buildStates,
+		RefreshMode: refreshMode,
+	}
+
+	builds, err := bk.ListBuilds(time.Now().Add(-*since), StatePredicate{States: buildStates})
+	if err != nil {
+		log.Fatalf("listing builds: %v", err)
+	}
+
+	log.Printf("fetched %d builds: %+v\n", len(builds), countByState(builds))
+}
+
+// StatePredicate is a BuildPredicate that accepts only builds whose State
+// is one of States, e.g. to compute aggregate failure/cancel statistics
+// once NetworkBuildkite.BuildStates includes more than "passed".
+type StatePredicate struct {
+	States []string
+}
+
+func (p StatePredicate) Predicate(b Build) bool {
+	for _, state := range p.States {
+		if b.State == state {
+			return true
+		}
+	}
+	return false
+}
+
+// countByState tallies builds per Build.State, e.g. to report how many
+// failed or were canceled alongside how many passed.
+func countByState(builds []Build) map[string]int {
+	counts := make(map[string]int)
+	for _, b := range builds {
+		counts[b.State]++
+	}
+	return counts
+}
+
+func serveMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+
+	log.Printf("serving metrics on %s\n", addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		log.Printf("metrics server stopped: %v\n", err)
+	}
+}
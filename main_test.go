@@ -0,0 +1,40 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestStatePredicate(t *testing.T) {
+	pred := StatePredicate{States: []string{"failed", "canceled"}}
+
+	tests := []struct {
+		state string
+		want  bool
+	}{
+		{"failed", true},
+		{"canceled", true},
+		{"passed", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := pred.Predicate(Build{State: tt.state}); got != tt.want {
+			t.Errorf("Predicate(Build{State: %q}) = %v, want %v", tt.state, got, tt.want)
+		}
+	}
+}
+
+func TestCountByState(t *testing.T) {
+	builds := []Build{
+		{State: "passed"},
+		{State: "passed"},
+		{State: "failed"},
+		{State: "canceled"},
+	}
+
+	got := countByState(builds)
+	want := map[string]int{"passed": 2, "failed": 1, "canceled": 1}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("countByState() = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	apiRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "buildkite_api_requests_total",
+		Help: "Total number of requests made to the Buildkite API, by outcome.",
+	}, []string{"status"})
+
+	cacheHitsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "buildkite_cache_hits_total",
+		Help: "Total number of cache lookups that found a usable entry.",
+	})
+
+	cacheMissesTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "buildkite_cache_misses_total",
+		Help: "Total number of cache lookups that found no usable entry.",
+	})
+
+	apiRequestDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "buildkite_api_request_duration_seconds",
+		Help:    "Latency of individual Buildkite API requests.",
+		Buckets: prometheus.DefBuckets,
+	})
+
+	listBuildsDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "buildkite_list_builds_duration_seconds",
+		Help:    "Latency of fetching all builds for a single daily interval.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"interval_age_bucket"})
+
+	rateLimitRemaining = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "buildkite_rate_limit_remaining",
+		Help: "Last observed value of Buildkite's RateLimit-Remaining response header.",
+	})
+)
+
+// intervalAgeBucket labels a timeInterval for the list_builds_duration
+// histogram: "recent" for intervals still inside the aggressive cache-TTL
+// cutoff, "historical" otherwise.
+func intervalAgeBucket(b *NetworkBuildkite, interval timeInterval) string {
+	if b.isRecentInterval(interval) {
+		return "recent"
+	}
+	return "historical"
+}
+
+// recordRateLimitRemaining parses the RateLimit-Remaining header value, if
+// any, and sets the gauge.
+func recordRateLimitRemaining(header string) {
+	if header == "" {
+		return
+	}
+	if remaining, err := strconv.ParseFloat(header, 64); err == nil {
+		rateLimitRemaining.Set(remaining)
+	}
+}
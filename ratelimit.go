@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// maxRateLimitRetries bounds how many times rateLimitRoundTripper will retry
+// a single request that keeps coming back 429, before handing the last
+// response back to the caller.
+const maxRateLimitRetries = 5
+
+// rateLimitRoundTripper retries HTTP 429 responses honouring Buildkite's
+// Retry-After header before returning to the caller.
+//
+// This has to live below go-buildkite's Client rather than in
+// queryWithRetry: Client.Do already retries a GET 429 internally via its own
+// backoff.RetryNotify loop, and that loop only ever sees a bare error, never
+// the *http.Response - so nothing above it can read RateLimit-Remaining or
+// Retry-After. Installing this as the Client's Transport lets it see (and
+// wait out) the real response before go-buildkite's retry has a chance to
+// swallow it.
+type rateLimitRoundTripper struct {
+	// Transport is the underlying RoundTripper to delegate to. Defaults to
+	// http.DefaultTransport when nil.
+	Transport http.RoundTripper
+}
+
+func (t *rateLimitRoundTripper) transport() http.RoundTripper {
+	if t.Transport != nil {
+		return t.Transport
+	}
+	return http.DefaultTransport
+}
+
+func (t *rateLimitRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 1; ; attempt++ {
+		resp, err := t.transport().RoundTrip(req)
+		if err != nil || resp.StatusCode != http.StatusTooManyRequests || attempt == maxRateLimitRetries+1 {
+			return resp, err
+		}
+
+		wait := retryAfter(resp.Header)
+		resp.Body.Close()
+
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// retryAfter parses the Retry-After header, falling back to a flat second
+// when it's missing or malformed.
+func retryAfter(h http.Header) time.Duration {
+	if secs, err := strconv.Atoi(h.Get("Retry-After")); err == nil {
+		return time.Duration(secs) * time.Second
+	}
+	return time.Second
+}